@@ -0,0 +1,224 @@
+// Package encoding provides pluggable response encoders for the
+// annotation handlers, selected by content negotiation on the Accept
+// header. JSON is the default; CSV and XML are offered for clients
+// that expect the format families common to echoip/freegeoip-style
+// geo services.
+package encoding
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/m-lab/etl/schema"
+)
+
+// Error is the payload of a structured error response, encoded as
+// {"error":{"code":"...","message":"..."}} in JSON and analogously in
+// CSV/XML.
+type Error struct {
+	Code    string `json:"code" xml:"code"`
+	Message string `json:"message" xml:"message"`
+}
+
+type jsonErrorEnvelope struct {
+	Error Error `json:"error"`
+}
+
+type xmlErrorEnvelope struct {
+	XMLName xml.Name `xml:"error"`
+	Error
+}
+
+// Encoder writes annotation results and errors in one wire format.
+type Encoder interface {
+	// ContentType is the value to send in the response's Content-Type
+	// header.
+	ContentType() string
+
+	// EncodeResult writes the metadata for a single /annotate lookup.
+	EncodeResult(w io.Writer, result *schema.MetaData) error
+
+	// EncodeBatch writes the metadata for a /batch_annotate lookup,
+	// keyed the same way the map returned to callers always has been:
+	// IP concatenated with the base-36 encoded timestamp.
+	EncodeBatch(w io.Writer, results map[string]*schema.MetaData) error
+
+	// EncodeError writes a structured error envelope.
+	EncodeError(w io.Writer, e Error) error
+}
+
+// encoderFor maps the supported media types to their Encoder. "*/*"
+// resolves to the JSON default, matching the API's historical
+// behavior when a client expresses no real preference.
+var encoderFor = map[string]Encoder{
+	"application/json": jsonEncoder{},
+	"text/csv":         csvEncoder{},
+	"application/xml":  xmlEncoder{},
+	"text/xml":         xmlEncoder{},
+	"*/*":              jsonEncoder{},
+}
+
+// mediaRange is one comma-separated entry of an Accept header, e.g.
+// "text/csv;q=0.9".
+type mediaRange struct {
+	mediaType string
+	q         float64
+}
+
+// parseAccept splits an Accept header into its media ranges, in the
+// order they were listed, defaulting q to 1.0 when absent or
+// unparsable.
+func parseAccept(accept string) []mediaRange {
+	var ranges []mediaRange
+	for _, entry := range strings.Split(accept, ",") {
+		parts := strings.Split(entry, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(parts[0]))
+		if mediaType == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if parsed, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimPrefix(param, "q=")), 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		ranges = append(ranges, mediaRange{mediaType: mediaType, q: q})
+	}
+	return ranges
+}
+
+// ForAccept picks an Encoder based on the request's Accept header,
+// honoring q-values. JSON is the default, matching the API's
+// historical behavior, used when the header is empty or names no
+// type we support.
+func ForAccept(accept string) Encoder {
+	ranges := parseAccept(accept)
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+	for _, r := range ranges {
+		if enc, ok := encoderFor[r.mediaType]; ok && r.q > 0 {
+			return enc
+		}
+	}
+	return jsonEncoder{}
+}
+
+type jsonEncoder struct{}
+
+func (jsonEncoder) ContentType() string { return "application/json" }
+
+func (jsonEncoder) EncodeResult(w io.Writer, result *schema.MetaData) error {
+	return json.NewEncoder(w).Encode(result)
+}
+
+func (jsonEncoder) EncodeBatch(w io.Writer, results map[string]*schema.MetaData) error {
+	return json.NewEncoder(w).Encode(results)
+}
+
+func (jsonEncoder) EncodeError(w io.Writer, e Error) error {
+	return json.NewEncoder(w).Encode(jsonErrorEnvelope{Error: e})
+}
+
+// csvHeader and csvRow define the single-row-per-IP CSV layout shared
+// by EncodeResult and EncodeBatch; EncodeBatch adds a leading "key"
+// column to identify each row.
+var csvHeader = []string{"country_code", "country_name", "city", "postal_code", "latitude", "longitude", "as_number", "as_name"}
+
+func csvRow(result *schema.MetaData) []string {
+	row := make([]string, len(csvHeader))
+	if result == nil {
+		return row
+	}
+	if result.Geo != nil {
+		row[0] = result.Geo.Country_code
+		row[1] = result.Geo.Country_name
+		row[2] = result.Geo.City
+		row[3] = result.Geo.Postal_code
+		row[4] = strconv.FormatFloat(result.Geo.Latitude, 'f', -1, 64)
+		row[5] = strconv.FormatFloat(result.Geo.Longitude, 'f', -1, 64)
+	}
+	if result.ASN != nil {
+		row[6] = strconv.FormatInt(result.ASN.ASNumber, 10)
+		row[7] = result.ASN.ASName
+	}
+	return row
+}
+
+type csvEncoder struct{}
+
+func (csvEncoder) ContentType() string { return "text/csv" }
+
+func (csvEncoder) EncodeResult(w io.Writer, result *schema.MetaData) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	if err := cw.Write(csvRow(result)); err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func (csvEncoder) EncodeBatch(w io.Writer, results map[string]*schema.MetaData) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(append([]string{"key"}, csvHeader...)); err != nil {
+		return err
+	}
+	for key, result := range results {
+		if err := cw.Write(append([]string{key}, csvRow(result)...)); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func (csvEncoder) EncodeError(w io.Writer, e Error) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"code", "message"}); err != nil {
+		return err
+	}
+	if err := cw.Write([]string{e.Code, e.Message}); err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+type xmlEncoder struct{}
+
+func (xmlEncoder) ContentType() string { return "application/xml" }
+
+func (xmlEncoder) EncodeResult(w io.Writer, result *schema.MetaData) error {
+	return xml.NewEncoder(w).Encode(result)
+}
+
+type xmlBatchEntry struct {
+	Key    string `xml:"key,attr"`
+	Result *schema.MetaData
+}
+
+type xmlBatch struct {
+	XMLName xml.Name        `xml:"results"`
+	Entries []xmlBatchEntry `xml:"result"`
+}
+
+func (xmlEncoder) EncodeBatch(w io.Writer, results map[string]*schema.MetaData) error {
+	batch := xmlBatch{Entries: make([]xmlBatchEntry, 0, len(results))}
+	for key, result := range results {
+		batch.Entries = append(batch.Entries, xmlBatchEntry{Key: key, Result: result})
+	}
+	return xml.NewEncoder(w).Encode(batch)
+}
+
+func (xmlEncoder) EncodeError(w io.Writer, e Error) error {
+	return xml.NewEncoder(w).Encode(xmlErrorEnvelope{Error: e})
+}
@@ -0,0 +1,64 @@
+package encoding
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/m-lab/etl/schema"
+)
+
+func TestForAccept(t *testing.T) {
+	cases := []struct {
+		accept string
+		want   string
+	}{
+		{"text/csv", "text/csv"},
+		{"application/xml", "application/xml"},
+		{"application/xml, text/csv;q=0.9", "application/xml"},
+		{"application/json", "application/json"},
+		{"", "application/json"},
+	}
+	for _, c := range cases {
+		if got := ForAccept(c.accept).ContentType(); got != c.want {
+			t.Errorf("ForAccept(%q).ContentType() = %q, want %q", c.accept, got, c.want)
+		}
+	}
+}
+
+func TestCSVEncoderEncodeResult(t *testing.T) {
+	result := &schema.MetaData{
+		Geo: &schema.GeolocationIP{Country_code: "US", City: "Mountain View"},
+		ASN: &schema.IPASNData{ASNumber: 15169, ASName: "Google"},
+	}
+	var buf bytes.Buffer
+	if err := (csvEncoder{}).EncodeResult(&buf, result); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if !strings.Contains(out, "US") || !strings.Contains(out, "Mountain View") {
+		t.Errorf("EncodeResult output missing geo fields: %q", out)
+	}
+	if !strings.Contains(out, "15169") || !strings.Contains(out, "Google") {
+		t.Errorf("EncodeResult output missing ASN fields: %q", out)
+	}
+}
+
+func TestCSVEncoderEncodeResultHandlesNil(t *testing.T) {
+	var buf bytes.Buffer
+	if err := (csvEncoder{}).EncodeResult(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestXMLEncoderEncodeError(t *testing.T) {
+	var buf bytes.Buffer
+	e := Error{Code: "rate_limit", Message: "Request quota exceeded"}
+	if err := (xmlEncoder{}).EncodeError(&buf, e); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if !strings.Contains(out, "rate_limit") || !strings.Contains(out, "Request quota exceeded") {
+		t.Errorf("EncodeError output missing fields: %q", out)
+	}
+}
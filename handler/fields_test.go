@@ -0,0 +1,31 @@
+package handler
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/m-lab/annotation-service/search"
+)
+
+func TestParseFields(t *testing.T) {
+	cases := []struct {
+		name  string
+		query string
+		want  search.Fields
+	}{
+		{"absent", "", search.AllFields},
+		{"geo only", "fields=geo", search.Fields{Geo: true}},
+		{"asn only", "fields=asn", search.Fields{ASN: true}},
+		{"both", "fields=geo,asn", search.AllFields},
+		{"unrecognized falls back to everything", "fields=typo", search.AllFields},
+		{"present but empty falls back to everything", "fields=", search.AllFields},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/annotate?"+c.query, nil)
+			if got := parseFields(r); got != c.want {
+				t.Errorf("parseFields(%q) = %+v, want %+v", c.query, got, c.want)
+			}
+		})
+	}
+}
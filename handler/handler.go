@@ -3,6 +3,7 @@ package handler
 import (
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -10,9 +11,11 @@ import (
 	"net"
 	"net/http"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/m-lab/annotation-service/handler/encoding"
 	"github.com/m-lab/annotation-service/metrics"
 	"github.com/m-lab/annotation-service/parser"
 	"github.com/m-lab/annotation-service/search"
@@ -27,6 +30,28 @@ var currentDataMutex = &sync.RWMutex{}
 // latest data for the annotator to search and reply with
 var CurrentGeoDataset *parser.GeoDataset = nil
 
+// CurrentBackend is the search.Backend currently serving /annotate and
+// /batch_annotate requests. It is nil until InitBackend is called.
+var CurrentBackend search.Backend = nil
+
+var (
+	// backendMode selects which search.Backend implementation serves
+	// lookups: "csv" (default) wraps CurrentGeoDataset, loaded from the
+	// GeoLite2 CSV distribution; "mmdb" reads directly from MaxMind
+	// binary database files.
+	backendMode = flag.String("backend", "csv", "annotation backend to use: csv or mmdb")
+
+	mmdbCityPath = flag.String("mmdb.city", "", "path to a GeoLite2-City.mmdb file, required when -backend=mmdb")
+	mmdbASNPath  = flag.String("mmdb.asn", "", "path to a GeoLite2-ASN.mmdb file, optional when -backend=mmdb")
+
+	// asnZipPath, when set, is attached to every csv-backed GeoDataset
+	// this process loads: the one InitBackend builds at startup, every
+	// release the reload watcher swaps in, and every release
+	// DatasetRegistry loads on demand. Without it, IPASNData stays
+	// empty for the csv backend.
+	asnZipPath = flag.String("asn.zip", "", "path to a GeoLite2 ASN CSV zip, attached to every csv-backed GeoDataset so IPASNData gets populated")
+)
+
 // This is the base in which we should encode the timestamp when we
 // are creating the keys for the mapt to return for batch requests
 const encodingBase = 36
@@ -34,13 +59,42 @@ const encodingBase = 36
 // A function to set up any handlers that are needed, including url
 // handlers and pubsub handlers
 func SetupHandlers() {
-	http.HandleFunc("/annotate", Annotate)
-	http.HandleFunc("/batch_annotate", BatchAnnotate)
+	http.HandleFunc("/annotate", Quota(Annotate))
+	http.HandleFunc("/batch_annotate", Quota(BatchAnnotate))
+	http.HandleFunc("/status", StatusHandler)
 	go waitForDownloaderMessages()
 }
 
-// Annotate is a URL handler that looks up IP address and puts
-// metadata out to the response encoded in json format.
+// InitBackend selects and initializes CurrentBackend according to
+// -backend. For the csv backend it wraps CurrentGeoDataset, so it must
+// be called after the dataset has been loaded; for the mmdb backend it
+// opens the configured mmdb files itself.
+func InitBackend() error {
+	switch *backendMode {
+	case "mmdb":
+		dataset, err := parser.LoadGeoLite2MMDB(*mmdbCityPath, *mmdbASNPath)
+		if err != nil {
+			return err
+		}
+		CurrentBackend = search.NewMMDBBackend(dataset)
+	case "csv":
+		if *asnZipPath != "" {
+			if err := parser.LoadASNFile(CurrentGeoDataset, *asnZipPath); err != nil {
+				return err
+			}
+		}
+		CurrentBackend = search.NewIndexBackend(CurrentGeoDataset)
+	default:
+		return fmt.Errorf("unknown annotation backend %q", *backendMode)
+	}
+	return nil
+}
+
+// Annotate is a URL handler that looks up an IP address and writes its
+// metadata to the response, encoded according to the request's Accept
+// header (JSON by default). Errors are reported as a structured
+// {"error":{"code":...,"message":...}} envelope with a matching HTTP
+// status code, rather than HTTP 200 with a plain-text body.
 func Annotate(w http.ResponseWriter, r *http.Request) {
 	// Setup timers and counters for prometheus metrics.
 	timerStart := time.Now()
@@ -51,19 +105,60 @@ func Annotate(w http.ResponseWriter, r *http.Request) {
 	metrics.Metrics_totalRequests.Inc()
 	defer metrics.Metrics_activeRequests.Dec()
 
+	enc := encoding.ForAccept(r.Header.Get("Accept"))
+	w.Header().Set("Content-Type", enc.ContentType())
+
 	data, err := ValidateAndParse(r)
 	if err != nil {
-		fmt.Fprintf(w, "Invalid request")
+		writeError(w, enc, http.StatusBadRequest, "invalid_request", err.Error())
 		return
 	}
 
-	result := GetMetadataForSingleIP(data)
-	encodedResult, err := json.Marshal(result)
+	result, err := GetMetadataForSingleIPWithFields(data, parseFields(r))
 	if err != nil {
-		fmt.Fprintf(w, "Unknown JSON Encoding Error")
+		writeError(w, enc, statusForLookupError(err), codeForLookupError(err), err.Error())
 		return
 	}
-	fmt.Fprint(w, string(encodedResult))
+
+	if err := enc.EncodeResult(w, result); err != nil {
+		log.Println("encoding /annotate result:", err)
+	}
+}
+
+// writeError sends status and writes e through enc. Every error path of
+// Annotate, BatchAnnotate and Quota funnels through it, so none of them
+// can regress back to HTTP 200 with a plain-text body. StatusHandler
+// does not use it; it reports its own failure with http.Error.
+func writeError(w http.ResponseWriter, enc encoding.Encoder, status int, code, message string) {
+	w.WriteHeader(status)
+	if err := enc.EncodeError(w, encoding.Error{Code: code, Message: message}); err != nil {
+		log.Println("encoding error response:", err)
+	}
+}
+
+// ErrBackendUnavailable is returned by GetMetadataForSingleIPWithFields
+// when no search.Backend has been configured yet (InitBackend hasn't
+// run, or no -reload/-history source is ready).
+var ErrBackendUnavailable = errors.New("annotation backend not ready")
+
+// statusForLookupError maps an error returned by
+// GetMetadataForSingleIPWithFields to the HTTP status code it should
+// produce: 503 when there's no backend to ask, 404 when a backend was
+// asked but couldn't resolve the IP.
+func statusForLookupError(err error) int {
+	if err == ErrBackendUnavailable {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusNotFound
+}
+
+// codeForLookupError is the error code counterpart of
+// statusForLookupError.
+func codeForLookupError(err error) string {
+	if err == ErrBackendUnavailable {
+		return "backend_unavailable"
+	}
+	return "not_found"
 }
 
 // ValidateAndParse takes a request and validates the URL parameters,
@@ -89,6 +184,33 @@ func ValidateAndParse(r *http.Request) (*schema.RequestData, error) {
 	return &schema.RequestData{ip, 6, time.Unix(time_milli, 0)}, nil
 }
 
+// parseFields reads the "fields" query parameter, a comma-separated
+// list of "geo" and/or "asn", and turns it into a search.Fields so
+// clients that only need one can skip paying for the other lookup. An
+// absent or unrecognized fields parameter requests everything.
+func parseFields(r *http.Request) search.Fields {
+	requested := r.URL.Query().Get("fields")
+	if requested == "" {
+		return search.AllFields
+	}
+	var fields search.Fields
+	var recognized bool
+	for _, f := range strings.Split(requested, ",") {
+		switch strings.TrimSpace(f) {
+		case "geo":
+			fields.Geo = true
+			recognized = true
+		case "asn":
+			fields.ASN = true
+			recognized = true
+		}
+	}
+	if !recognized {
+		return search.AllFields
+	}
+	return fields
+}
+
 // BatchAnnotate is a URL handler that expects the body of the request
 // to contain a JSON encoded slice of schema.RequestDatas. It will
 // look up all the ip addresses and bundle them into a map of metadata
@@ -104,26 +226,53 @@ func BatchAnnotate(w http.ResponseWriter, r *http.Request) {
 	metrics.Metrics_totalRequests.Inc()
 	defer metrics.Metrics_activeRequests.Dec()
 
+	enc := encoding.ForAccept(r.Header.Get("Accept"))
+	w.Header().Set("Content-Type", enc.ContentType())
+
+	r.Body = http.MaxBytesReader(w, r.Body, *maxBatchBodyBytes)
 	dataSlice, err := BatchValidateAndParse(r.Body)
 	r.Body.Close()
 
 	if err != nil {
-		fmt.Println(err)
-		fmt.Fprintf(w, "Invalid Request!")
+		if err == errBatchTooLarge || isRequestTooLarge(err) {
+			// 413, not 429: this rejects a single oversized request on its
+			// own merits, independent of how much quota the caller has
+			// left, so "Payload Too Large" is the more accurate status.
+			// Quota.go's rate limiter is the one that speaks for a budget
+			// across requests and returns 429 for that.
+			metrics.Metrics_quotaRejectionsTotal.WithLabelValues("batch_too_large").Inc()
+			writeError(w, enc, http.StatusRequestEntityTooLarge, "batch_too_large", "Batch request too large")
+			return
+		}
+		writeError(w, enc, http.StatusBadRequest, "invalid_request", err.Error())
 		return
 	}
+	metrics.Metrics_batchSize.Observe(float64(len(dataSlice)))
 
 	responseMap := make(map[string]*schema.MetaData)
 	for _, data := range dataSlice {
-		responseMap[data.IP+strconv.FormatInt(data.Timestamp.Unix(), encodingBase)] = GetMetadataForSingleIP(&data)
+		result, err := GetMetadataForSingleIP(&data)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		responseMap[data.IP+strconv.FormatInt(data.Timestamp.Unix(), encodingBase)] = result
 	}
-	encodedResult, err := json.Marshal(responseMap)
-	if err != nil {
-		fmt.Fprintf(w, "Unknown JSON Encoding Error")
-		return
+
+	if err := enc.EncodeBatch(w, responseMap); err != nil {
+		log.Println("encoding /batch_annotate result:", err)
 	}
-	fmt.Fprint(w, string(encodedResult))
+}
+
+// errBatchTooLarge is returned by BatchValidateAndParse when the
+// request carries more IPs than -quota.batch_max_size allows. Reported
+// to the client as 413, not 429 (see BatchAnnotate).
+var errBatchTooLarge = errors.New("batch request exceeds max size")
 
+// isRequestTooLarge reports whether err came from an http.MaxBytesReader
+// rejecting a body over -quota.batch_max_bytes.
+func isRequestTooLarge(err error) bool {
+	return strings.Contains(err.Error(), "http: request body too large")
 }
 
 // BatchValidateAndParse will take a reader (likely the body of a
@@ -143,6 +292,9 @@ func BatchValidateAndParse(source io.Reader) ([]schema.RequestData, error) {
 	if err != nil {
 		return nil, err
 	}
+	if len(uncheckedData) > *maxBatchSize {
+		return nil, errBatchTooLarge
+	}
 	for _, data := range uncheckedData {
 		newIP := net.ParseIP(data.IP)
 		if newIP == nil {
@@ -159,55 +311,35 @@ func BatchValidateAndParse(source io.Reader) ([]schema.RequestData, error) {
 
 // GetMetadataForSingleIP takes a pointer to a schema.RequestData
 // struct and will use it to fetch the appropriate associated
-// metadata, returning a pointer. It is gaurenteed to return a non-nil
-// pointer, even if it cannot find the appropriate metadata.
-func GetMetadataForSingleIP(request *schema.RequestData) *schema.MetaData {
+// metadata, returning a pointer. The error return mirrors
+// search.Backend.Annotate's: non-nil only when the metadata could not
+// be produced, in which case the pointer is nil.
+func GetMetadataForSingleIP(request *schema.RequestData) (*schema.MetaData, error) {
+	return GetMetadataForSingleIPWithFields(request, search.AllFields)
+}
+
+// GetMetadataForSingleIPWithFields is GetMetadataForSingleIP, but lets
+// the caller restrict the lookup to a subset of fields (e.g. geo-only),
+// which is cheaper when the other fields aren't needed.
+func GetMetadataForSingleIPWithFields(request *schema.RequestData, fields search.Fields) (*schema.MetaData, error) {
 	metrics.Metrics_totalLookups.Inc()
-	if CurrentGeoDataset == nil {
-		// TODO: Block until the value is not nil
-		return nil
-	}
-	// TODO: Figure out which table to use based on time
-	err := errors.New("Unknown IP Format!")
-	currentDataMutex.RLock()
-	defer currentDataMutex.RUnlock()
-	var node parser.IPNode
-	// TODO: Push this logic down to searchlist (after binary search is implemented)
-	if request.IPFormat == 4 {
-		node, err = search.SearchList(CurrentGeoDataset.IP4Nodes, request.IP)
-	} else if request.IPFormat == 6 {
-		node, err = search.SearchList(CurrentGeoDataset.IP6Nodes, request.IP)
-	}
 
-	if err != nil {
-		log.Println(err)
-		//TODO metric here
-		return nil
+	// When a DatasetRegistry is configured, resolve the request's
+	// timestamp to the archived dataset that was in effect then,
+	// rather than always answering from the live CurrentBackend.
+	if CurrentRegistry != nil {
+		dataset, err := CurrentRegistry.Get(request.Timestamp)
+		if err != nil {
+			return nil, err
+		}
+		return search.NewIndexBackend(dataset).Annotate(request.IPFormat, request.IP, fields)
 	}
 
-	return ConvertIPNodeToMetaData(node, CurrentGeoDataset.LocationNodes)
-}
+	currentDataMutex.RLock()
+	defer currentDataMutex.RUnlock()
 
-// ConvertIPNodeToMetaData takes a parser.IPNode, plus a list of
-// locationNodes. It will then use that data to fill in a MetaData
-// struct and return its pointer.
-func ConvertIPNodeToMetaData(ipNode parser.IPNode, locationNodes []parser.LocationNode) *schema.MetaData {
-	locNode := parser.LocationNode{}
-	if ipNode.LocationIndex >= 0 {
-		locNode = locationNodes[ipNode.LocationIndex]
-	}
-	return &schema.MetaData{
-		Geo: &schema.GeolocationIP{
-			Continent_code: locNode.ContinentCode,
-			Country_code:   locNode.CountryCode,
-			Country_name:   locNode.CountryName,
-			Postal_code:    ipNode.PostalCode,
-			Metro_code:     locNode.MetroCode,
-			City:           locNode.CityName,
-			Latitude:       ipNode.Latitude,
-			Longitude:      ipNode.Longitude,
-		},
-		ASN: &schema.IPASNData{},
+	if CurrentBackend == nil {
+		return nil, ErrBackendUnavailable
 	}
-
+	return CurrentBackend.Annotate(request.IPFormat, request.IP, fields)
 }
@@ -0,0 +1,156 @@
+package handler
+
+import (
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/m-lab/annotation-service/handler/encoding"
+	"github.com/m-lab/annotation-service/metrics"
+)
+
+var (
+	quotaRequestsPerWindow = flag.Int("quota.requests", 300, "max requests allowed per source IP per quota window")
+	quotaWindow            = flag.Duration("quota.window", time.Minute, "fixed window duration over which quota.requests is enforced")
+	quotaRedisAddr         = flag.String("quota.redis", "", "redis address (host:port) for a shared quota limiter; if unset, quota falls back to an in-memory, per-instance limiter")
+
+	maxBatchBodyBytes = flag.Int64("quota.batch_max_bytes", 1<<20, "maximum accepted BatchAnnotate request body size, in bytes")
+	maxBatchSize      = flag.Int("quota.batch_max_size", 1000, "maximum number of IPs accepted in a single BatchAnnotate request")
+)
+
+// Limiter enforces a request budget per key (typically a source IP)
+// over a window. Both implementations here use a fixed window: a key's
+// whole budget resets at once when the window that began with its first
+// counted request has elapsed; the window does not slide with each
+// request. CurrentLimiter defaults to an in-memory
+// TokenBucketLimiter; -quota.redis switches it to a RedisLimiter
+// shared across every instance of the service.
+type Limiter interface {
+	// Allow reports whether key may make another request right now.
+	Allow(key string) bool
+}
+
+// CurrentLimiter is the Limiter enforced by the Quota middleware. It is
+// nil until InitQuota is called, in which case Quota lets all requests
+// through.
+var CurrentLimiter Limiter
+
+// InitQuota builds CurrentLimiter from -quota.requests, -quota.window
+// and -quota.redis. It must run after flag.Parse.
+func InitQuota() {
+	if *quotaRedisAddr != "" {
+		limiter, err := NewRedisLimiter(*quotaRedisAddr, *quotaRequestsPerWindow, *quotaWindow)
+		if err != nil {
+			log.Println("quota: could not reach redis, falling back to an in-memory limiter:", err)
+		} else {
+			CurrentLimiter = limiter
+			return
+		}
+	}
+	CurrentLimiter = NewTokenBucketLimiter(*quotaRequestsPerWindow, *quotaWindow)
+}
+
+// TokenBucketLimiter is an in-memory Limiter: each key gets its own
+// bucket holding up to burst tokens, which fully refill burst
+// requests after window has elapsed since the bucket was last reset. A
+// background sweep evicts buckets that have sat idle past their
+// window, so a public endpoint seeing many distinct source IPs doesn't
+// grow the map without bound.
+type TokenBucketLimiter struct {
+	burst  int
+	window time.Duration
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens      int
+	windowStart time.Time
+}
+
+// NewTokenBucketLimiter returns a Limiter allowing up to burst requests
+// per key every window.
+func NewTokenBucketLimiter(burst int, window time.Duration) *TokenBucketLimiter {
+	l := &TokenBucketLimiter{burst: burst, window: window, buckets: make(map[string]*tokenBucket)}
+	go l.sweepLoop()
+	return l
+}
+
+// sweepLoop evicts expired buckets once per window, for the lifetime
+// of the process.
+func (l *TokenBucketLimiter) sweepLoop() {
+	ticker := time.NewTicker(l.window)
+	defer ticker.Stop()
+	for range ticker.C {
+		l.sweep()
+	}
+}
+
+// sweep removes every bucket whose window has already elapsed. A
+// bucket that's still within its window is left alone even if it's
+// about to expire, since sweep only runs once per window anyway.
+func (l *TokenBucketLimiter) sweep() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	for key, b := range l.buckets {
+		if now.Sub(b.windowStart) >= l.window {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Allow implements Limiter.
+func (l *TokenBucketLimiter) Allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	b, ok := l.buckets[key]
+	if !ok || now.Sub(b.windowStart) >= l.window {
+		b = &tokenBucket{tokens: l.burst, windowStart: now}
+		l.buckets[key] = b
+	}
+	if b.tokens <= 0 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Quota wraps next with per-source-IP rate limiting: requests beyond
+// the configured budget are rejected with 429 before next ever runs.
+// This is a budget exhausted across requests, which is what 429 means;
+// BatchAnnotate's oversized-batch/body rejections are a property of
+// the single request instead, so those use 413 (see errBatchTooLarge
+// and isRequestTooLarge in handler.go).
+func Quota(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if CurrentLimiter == nil {
+			next(w, r)
+			return
+		}
+		if !CurrentLimiter.Allow(sourceIP(r)) {
+			metrics.Metrics_quotaRejectionsTotal.WithLabelValues("rate_limit").Inc()
+			enc := encoding.ForAccept(r.Header.Get("Accept"))
+			w.Header().Set("Content-Type", enc.ContentType())
+			writeError(w, enc, http.StatusTooManyRequests, "rate_limit", "Request quota exceeded")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// sourceIP extracts the caller's IP from r.RemoteAddr, stripping the
+// port added by net/http.
+func sourceIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// RedisLimiter is a Limiter backed by a Redis INCR/EXPIRE counter per
+// key, so the quota is enforced across every instance of the service
+// rather than separately by each one.
+type RedisLimiter struct {
+	client *redis.Client
+	burst  int
+	window time.Duration
+}
+
+// NewRedisLimiter dials addr and returns a Limiter allowing up to
+// burst requests per key every window.
+func NewRedisLimiter(addr string, burst int, window time.Duration) (*RedisLimiter, error) {
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	if err := client.Ping().Err(); err != nil {
+		return nil, fmt.Errorf("connecting to redis at %s: %v", addr, err)
+	}
+	return &RedisLimiter{client: client, burst: burst, window: window}, nil
+}
+
+// Allow implements Limiter. Each key maps to a Redis counter that's
+// incremented on every call and expires after window, so it resets
+// itself without a separate sweep. A Redis error fails open, since a
+// quota outage shouldn't also take down the annotation service.
+func (l *RedisLimiter) Allow(key string) bool {
+	redisKey := "quota:" + key
+	count, err := l.client.Incr(redisKey).Result()
+	if err != nil {
+		log.Println("quota: redis check failed, allowing request:", err)
+		return true
+	}
+	if count == 1 {
+		if err := l.client.Expire(redisKey, l.window).Err(); err != nil {
+			// redisKey would otherwise never expire and lock this key
+			// out permanently; drop it and fail open for this call
+			// rather than leave a stuck counter behind.
+			log.Println("quota: redis expire failed, dropping counter and allowing request:", err)
+			l.client.Del(redisKey)
+			return true
+		}
+	}
+	return count <= int64(l.burst)
+}
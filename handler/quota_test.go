@@ -0,0 +1,49 @@
+package handler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucketLimiterAllowsUpToBurstThenRejects(t *testing.T) {
+	l := NewTokenBucketLimiter(3, time.Minute)
+	for i := 0; i < 3; i++ {
+		if !l.Allow("a") {
+			t.Fatalf("Allow(\"a\") = false on request %d, want true", i)
+		}
+	}
+	if l.Allow("a") {
+		t.Error("Allow(\"a\") = true after burst exhausted, want false")
+	}
+	if !l.Allow("b") {
+		t.Error("Allow(\"b\") = false, want true: a different key must have its own bucket")
+	}
+}
+
+func TestTokenBucketLimiterRefillsAfterWindow(t *testing.T) {
+	l := NewTokenBucketLimiter(1, 10*time.Millisecond)
+	if !l.Allow("a") {
+		t.Fatal("Allow(\"a\") = false on first request, want true")
+	}
+	if l.Allow("a") {
+		t.Fatal("Allow(\"a\") = true before the window elapsed, want false")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if !l.Allow("a") {
+		t.Error("Allow(\"a\") = false after the window elapsed, want true")
+	}
+}
+
+func TestTokenBucketLimiterSweepEvictsExpiredBuckets(t *testing.T) {
+	l := NewTokenBucketLimiter(1, 10*time.Millisecond)
+	l.Allow("a")
+	time.Sleep(20 * time.Millisecond)
+	l.sweep()
+
+	l.mu.Lock()
+	_, ok := l.buckets["a"]
+	l.mu.Unlock()
+	if ok {
+		t.Error("sweep() left an expired bucket behind")
+	}
+}
@@ -0,0 +1,178 @@
+package handler
+
+import (
+	"errors"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/m-lab/annotation-service/metrics"
+	"github.com/m-lab/annotation-service/parser"
+)
+
+var (
+	historyDir           = flag.String("history.dir", "", "directory of archived MaxMind releases named by effective date, enables historical dataset selection")
+	historyResidentLimit = flag.Int("history.resident_limit", 4, "max number of archived datasets kept resident in memory at once")
+)
+
+// CurrentRegistry, when non-nil, resolves a request's since_epoch
+// timestamp to the archived GeoDataset that was in effect at that
+// time, instead of always answering from CurrentBackend.
+var CurrentRegistry *DatasetRegistry
+
+// InitDatasetRegistry builds CurrentRegistry from -history.dir, when
+// set, enabling historical dataset selection by request timestamp. It
+// is a no-op if -history.dir was not provided.
+func InitDatasetRegistry() error {
+	if *historyDir == "" {
+		return nil
+	}
+	registry, err := NewDatasetRegistry(*historyDir, *historyResidentLimit)
+	if err != nil {
+		return err
+	}
+	CurrentRegistry = registry
+	return nil
+}
+
+// releaseFilenamePattern matches archived MaxMind releases named by
+// effective date, e.g. GeoLite2-City-2019-10-01.zip.
+var releaseFilenamePattern = regexp.MustCompile(`(\d{4}-\d{2}-\d{2})\.zip$`)
+
+// release describes one archived dataset release on disk.
+type release struct {
+	effectiveDate time.Time
+	path          string
+}
+
+// DatasetRegistry resolves a request timestamp to the GeoDataset in
+// effect at that time: the last release whose effectiveDate is no
+// later than the request. Releases are discovered once, at
+// construction time, but only loaded into memory on demand; at most
+// residentLimit loaded datasets are kept around, with the
+// least-recently-used one evicted to make room for a new one.
+type DatasetRegistry struct {
+	residentLimit int
+	releases      []release // sorted ascending by effectiveDate
+
+	mu       sync.Mutex
+	resident map[time.Time]*parser.GeoDataset
+	lru      []time.Time // least-recently-used first
+}
+
+// NewDatasetRegistry scans dir for archived releases matching
+// releaseFilenamePattern and returns a registry that keeps at most
+// residentLimit of them loaded in memory at once.
+func NewDatasetRegistry(dir string, residentLimit int) (*DatasetRegistry, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	releases := []release{}
+	for _, entry := range entries {
+		match := releaseFilenamePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+		effectiveDate, err := time.Parse("2006-01-02", match[1])
+		if err != nil {
+			continue
+		}
+		releases = append(releases, release{effectiveDate: effectiveDate, path: filepath.Join(dir, entry.Name())})
+	}
+	if len(releases) == 0 {
+		return nil, errors.New("no archived releases found in " + dir)
+	}
+	sort.Slice(releases, func(i, j int) bool {
+		return releases[i].effectiveDate.Before(releases[j].effectiveDate)
+	})
+	return &DatasetRegistry{
+		residentLimit: residentLimit,
+		releases:      releases,
+		resident:      make(map[time.Time]*parser.GeoDataset),
+	}, nil
+}
+
+// Get returns the GeoDataset in effect at the given time, loading it
+// from disk if it is not already resident. r.mu is held only to check
+// and update the resident map and LRU list, not across the load
+// itself, so a slow load of one release never stalls concurrent Gets
+// for releases that are already resident.
+func (r *DatasetRegistry) Get(at time.Time) (*parser.GeoDataset, error) {
+	rel, ok := r.effectiveReleaseFor(at)
+	if !ok {
+		return nil, errors.New("no archived release is old enough for " + at.String())
+	}
+	label := rel.effectiveDate.Format("2006-01-02")
+
+	r.mu.Lock()
+	if dataset, ok := r.resident[rel.effectiveDate]; ok {
+		metrics.Metrics_datasetCacheHits.WithLabelValues(label).Inc()
+		r.touch(rel.effectiveDate)
+		r.mu.Unlock()
+		return dataset, nil
+	}
+	metrics.Metrics_datasetCacheMisses.WithLabelValues(label).Inc()
+	r.mu.Unlock()
+
+	dataset, err := parser.LoadGeoLite2File(rel.path)
+	if err != nil {
+		return nil, err
+	}
+	if *asnZipPath != "" {
+		if err := parser.LoadASNFile(dataset, *asnZipPath); err != nil {
+			return nil, err
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if existing, ok := r.resident[rel.effectiveDate]; ok {
+		// Another Get loaded the same release first; keep its copy
+		// instead of clobbering it with this redundant load.
+		r.touch(rel.effectiveDate)
+		return existing, nil
+	}
+	r.resident[rel.effectiveDate] = dataset
+	r.touch(rel.effectiveDate)
+	r.evictIfNeeded()
+	return dataset, nil
+}
+
+// effectiveReleaseFor returns the last release with effectiveDate <=
+// at; releases is sorted ascending, so this is the release just before
+// the first one that's after `at`.
+func (r *DatasetRegistry) effectiveReleaseFor(at time.Time) (release, bool) {
+	i := sort.Search(len(r.releases), func(i int) bool {
+		return r.releases[i].effectiveDate.After(at)
+	}) - 1
+	if i < 0 {
+		return release{}, false
+	}
+	return r.releases[i], true
+}
+
+// touch marks effectiveDate as most recently used. Caller holds r.mu.
+func (r *DatasetRegistry) touch(effectiveDate time.Time) {
+	for i, d := range r.lru {
+		if d.Equal(effectiveDate) {
+			r.lru = append(r.lru[:i], r.lru[i+1:]...)
+			break
+		}
+	}
+	r.lru = append(r.lru, effectiveDate)
+}
+
+// evictIfNeeded drops least-recently-used resident datasets until at
+// most residentLimit remain. Caller holds r.mu.
+func (r *DatasetRegistry) evictIfNeeded() {
+	for len(r.resident) > r.residentLimit && len(r.lru) > 0 {
+		oldest := r.lru[0]
+		r.lru = r.lru[1:]
+		delete(r.resident, oldest)
+	}
+}
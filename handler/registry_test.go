@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestRegistry builds a DatasetRegistry by pointing
+// NewDatasetRegistry's directory scan at a set of empty, date-named
+// release files, so no real MaxMind zip is needed.
+func newTestRegistry(t *testing.T, dates []string, residentLimit int) *DatasetRegistry {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "registry-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	for _, d := range dates {
+		path := filepath.Join(dir, "GeoLite2-City-"+d+".zip")
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := NewDatasetRegistry(dir, residentLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestDatasetRegistryEffectiveReleaseFor(t *testing.T) {
+	r := newTestRegistry(t, []string{"2019-01-01", "2019-06-01", "2020-01-01"}, 4)
+
+	cases := []struct {
+		at   string
+		want string
+	}{
+		{"2019-03-01", "2019-01-01"},
+		{"2019-06-01", "2019-06-01"},
+		{"2025-01-01", "2020-01-01"},
+	}
+	for _, c := range cases {
+		at, err := time.Parse("2006-01-02", c.at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rel, ok := r.effectiveReleaseFor(at)
+		if !ok {
+			t.Errorf("effectiveReleaseFor(%s): no release found, want %s", c.at, c.want)
+			continue
+		}
+		if got := rel.effectiveDate.Format("2006-01-02"); got != c.want {
+			t.Errorf("effectiveReleaseFor(%s) = %s, want %s", c.at, got, c.want)
+		}
+	}
+
+	if _, ok := r.effectiveReleaseFor(time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)); ok {
+		t.Error("effectiveReleaseFor(before every release) = ok, want not ok")
+	}
+}
+
+func TestDatasetRegistryEvictsLeastRecentlyUsed(t *testing.T) {
+	r := newTestRegistry(t, []string{"2019-01-01", "2019-02-01", "2019-03-01"}, 2)
+
+	r.mu.Lock()
+	r.resident[r.releases[0].effectiveDate] = nil
+	r.touch(r.releases[0].effectiveDate)
+	r.resident[r.releases[1].effectiveDate] = nil
+	r.touch(r.releases[1].effectiveDate)
+	r.resident[r.releases[2].effectiveDate] = nil
+	r.touch(r.releases[2].effectiveDate)
+	r.evictIfNeeded()
+	_, oldestStillResident := r.resident[r.releases[0].effectiveDate]
+	_, newestStillResident := r.resident[r.releases[2].effectiveDate]
+	residentCount := len(r.resident)
+	r.mu.Unlock()
+
+	if residentCount != 2 {
+		t.Errorf("len(resident) = %d after evictIfNeeded, want 2", residentCount)
+	}
+	if oldestStillResident {
+		t.Error("the least-recently-used release is still resident, want evicted")
+	}
+	if !newestStillResident {
+		t.Error("the most-recently-used release was evicted, want resident")
+	}
+}
@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/m-lab/annotation-service/loader/watch"
+	"github.com/m-lab/annotation-service/metrics"
+	"github.com/m-lab/annotation-service/parser"
+	"github.com/m-lab/annotation-service/search"
+)
+
+var (
+	reloadSourceDir = flag.String("reload.dir", "", "local directory to watch for new GeoLite2 CSV zip releases")
+	reloadSourceURL = flag.String("reload.url", "", "HTTP(S) URL to poll for a new GeoLite2 CSV zip release")
+	reloadGCSBucket = flag.String("reload.gcs.bucket", "", "GCS bucket to poll for a new GeoLite2 CSV zip release, requires -reload.gcs.object")
+	reloadGCSObject = flag.String("reload.gcs.object", "", "object path within -reload.gcs.bucket to poll for a new GeoLite2 CSV zip release")
+	reloadInterval  = flag.Duration("reload.interval", time.Hour, "how often to poll for a new dataset release")
+)
+
+// datasetBuiltAt records when the release backing CurrentGeoDataset was
+// published, for StatusHandler and monitoring.
+var datasetBuiltAt time.Time
+
+// reloadWatcher is non-nil once waitForDownloaderMessages has found a
+// configured dataset source.
+var reloadWatcher *watch.Watcher
+
+// waitForDownloaderMessages polls the configured dataset source (see
+// -reload.dir / -reload.url) for a newer MaxMind release and swaps it
+// in. It runs until the process exits; SetupHandlers starts it in its
+// own goroutine. If no source is configured, hot-reload is disabled
+// and CurrentGeoDataset is only ever the one loaded at startup.
+func waitForDownloaderMessages() {
+	source, err := reloadSource()
+	if err != nil {
+		log.Println("reload: hot-reload disabled:", err)
+		return
+	}
+	w := &watch.Watcher{
+		Source:       source,
+		PollInterval: *reloadInterval,
+		Load:         loadAndVerify,
+		Swap:         swapDataset,
+	}
+	currentDataMutex.Lock()
+	reloadWatcher = w
+	currentDataMutex.Unlock()
+	w.Run(nil)
+}
+
+func reloadSource() (watch.Source, error) {
+	switch {
+	case *reloadSourceDir != "":
+		return &watch.LocalDirSource{Dir: *reloadSourceDir, Pattern: "*.zip"}, nil
+	case *reloadSourceURL != "":
+		return &watch.HTTPSource{URL: *reloadSourceURL}, nil
+	case *reloadGCSBucket != "":
+		if *reloadGCSObject == "" {
+			return nil, errors.New("-reload.gcs.bucket was set without -reload.gcs.object")
+		}
+		return watch.NewGCSSource(*reloadGCSBucket, *reloadGCSObject), nil
+	default:
+		return nil, errors.New("neither -reload.dir, -reload.url, nor -reload.gcs.bucket was set")
+	}
+}
+
+// sampleLookupIPs are checked against every candidate release before it
+// is trusted; a release that can't resolve well-known public IPs is
+// assumed to be corrupt or truncated.
+var sampleLookupIPs = []struct {
+	ip       string
+	ipFormat int
+}{
+	{"8.8.8.8", 4},
+	{"1.1.1.1", 4},
+	{"2001:4860:4860::8888", 6},
+}
+
+// loadAndVerify parses path into a GeoDataset and runs a handful of
+// sample lookups against it, so a corrupt or truncated download never
+// reaches swapDataset.
+func loadAndVerify(path string) (*parser.GeoDataset, error) {
+	dataset, err := parser.LoadGeoLite2File(path)
+	if err != nil {
+		return nil, err
+	}
+	if *asnZipPath != "" {
+		if err := parser.LoadASNFile(dataset, *asnZipPath); err != nil {
+			return nil, err
+		}
+	}
+	backend := search.NewIndexBackend(dataset)
+	for _, sample := range sampleLookupIPs {
+		if _, err := backend.Annotate(sample.ipFormat, sample.ip, search.AllFields); err != nil {
+			return nil, err
+		}
+	}
+	return dataset, nil
+}
+
+// swapDataset installs dataset as the active GeoDataset and rebuilds
+// CurrentBackend to match it. currentDataMutex is held only for the
+// duration of the swap, so reloads never stall in-flight requests.
+func swapDataset(dataset *parser.GeoDataset, builtAt time.Time) {
+	newBackend := search.NewIndexBackend(dataset)
+
+	currentDataMutex.Lock()
+	CurrentGeoDataset = dataset
+	CurrentBackend = newBackend
+	datasetBuiltAt = builtAt
+	currentDataMutex.Unlock()
+
+	metrics.Metrics_datasetLoadSuccessTime.SetToCurrentTime()
+}
+
+// status is the JSON payload served by StatusHandler.
+type status struct {
+	DatasetBuiltAt time.Time `json:"dataset_built_at"`
+	NextCheck      time.Time `json:"next_check"`
+}
+
+// StatusHandler reports the build date of the dataset currently
+// serving requests and when the reload watcher will next poll for a
+// newer release.
+func StatusHandler(w http.ResponseWriter, r *http.Request) {
+	currentDataMutex.RLock()
+	s := status{DatasetBuiltAt: datasetBuiltAt}
+	watcher := reloadWatcher
+	currentDataMutex.RUnlock()
+	if watcher != nil {
+		s.NextCheck = watcher.NextCheck()
+	}
+	encoded, err := json.Marshal(s)
+	if err != nil {
+		http.Error(w, "Unknown JSON Encoding Error", http.StatusInternalServerError)
+		return
+	}
+	w.Write(encoded)
+}
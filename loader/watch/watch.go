@@ -0,0 +1,104 @@
+// Package watch implements polling-based hot-reload of the GeoDataset:
+// it periodically checks a Source for a newer MaxMind release,
+// downloads and verifies it, and hands the verified result to a
+// caller-supplied Swap callback.
+package watch
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/m-lab/annotation-service/metrics"
+	"github.com/m-lab/annotation-service/parser"
+)
+
+// Source describes anywhere a new dataset release might appear: a GCS
+// bucket, a local directory, or an HTTP URL. CheckNewer reports
+// whether a release newer than since is available and, if so,
+// downloads it to a local file and returns its path and modification
+// time.
+type Source interface {
+	CheckNewer(since time.Time) (path string, modTime time.Time, ok bool, err error)
+}
+
+// CleanupSource is implemented by Sources whose CheckNewer downloads a
+// release to a file that only exists for the caller's benefit, as
+// opposed to e.g. LocalDirSource, whose path points at a file the
+// operator manages and that must not be deleted out from under them.
+// Watcher calls Cleanup once it's done with path, whether or not the
+// release was ultimately accepted.
+type CleanupSource interface {
+	Cleanup(path string)
+}
+
+// Watcher periodically polls a Source for a newer dataset release,
+// verifies it, and invokes Swap with the result.
+type Watcher struct {
+	Source       Source
+	PollInterval time.Duration
+
+	// Load parses the downloaded file at path into a GeoDataset. The
+	// caller is expected to run a few sample lookups against the
+	// result before trusting it; see handler.loadAndVerify.
+	Load func(path string) (*parser.GeoDataset, error)
+
+	// Swap installs dataset, built from the release published at
+	// builtAt, as the one serving requests.
+	Swap func(dataset *parser.GeoDataset, builtAt time.Time)
+
+	lastModified time.Time
+
+	nextCheckMu sync.Mutex
+	nextCheck   time.Time
+}
+
+// Run polls Source every PollInterval until stop is closed.
+func (w *Watcher) Run(stop <-chan struct{}) {
+	for {
+		w.setNextCheck(time.Now().Add(w.PollInterval))
+		select {
+		case <-stop:
+			return
+		case <-time.After(w.PollInterval):
+			w.checkOnce()
+		}
+	}
+}
+
+func (w *Watcher) setNextCheck(t time.Time) {
+	w.nextCheckMu.Lock()
+	defer w.nextCheckMu.Unlock()
+	w.nextCheck = t
+}
+
+// NextCheck reports when the next poll is scheduled to run. Safe to
+// call concurrently with Run, which is what handler.StatusHandler does.
+func (w *Watcher) NextCheck() time.Time {
+	w.nextCheckMu.Lock()
+	defer w.nextCheckMu.Unlock()
+	return w.nextCheck
+}
+
+func (w *Watcher) checkOnce() {
+	path, modTime, ok, err := w.Source.CheckNewer(w.lastModified)
+	if err != nil {
+		log.Println("watch: checking for a new dataset release:", err)
+		metrics.Metrics_datasetReloadFailuresTotal.Inc()
+		return
+	}
+	if !ok {
+		return
+	}
+	dataset, err := w.Load(path)
+	if cleaner, ok := w.Source.(CleanupSource); ok {
+		cleaner.Cleanup(path)
+	}
+	if err != nil {
+		log.Println("watch: loading candidate release", path, ":", err)
+		metrics.Metrics_datasetReloadFailuresTotal.Inc()
+		return
+	}
+	w.Swap(dataset, modTime)
+	w.lastModified = modTime
+}
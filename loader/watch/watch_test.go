@@ -0,0 +1,100 @@
+package watch
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/m-lab/annotation-service/parser"
+)
+
+// fakeSource is a Source whose CheckNewer and Cleanup calls are scripted
+// by the test.
+type fakeSource struct {
+	path      string
+	modTime   time.Time
+	ok        bool
+	err       error
+	cleanedUp []string
+}
+
+func (s *fakeSource) CheckNewer(since time.Time) (string, time.Time, bool, error) {
+	return s.path, s.modTime, s.ok, s.err
+}
+
+func (s *fakeSource) Cleanup(path string) {
+	s.cleanedUp = append(s.cleanedUp, path)
+}
+
+func TestWatcherCheckOnceSwapsOnNewRelease(t *testing.T) {
+	modTime := time.Now()
+	source := &fakeSource{path: "release.zip", modTime: modTime, ok: true}
+	var loadedPath string
+	var swappedAt time.Time
+	w := &Watcher{
+		Source: source,
+		Load: func(path string) (*parser.GeoDataset, error) {
+			loadedPath = path
+			return &parser.GeoDataset{}, nil
+		},
+		Swap: func(dataset *parser.GeoDataset, builtAt time.Time) {
+			swappedAt = builtAt
+		},
+	}
+	w.checkOnce()
+
+	if loadedPath != "release.zip" {
+		t.Errorf("Load called with %q, want %q", loadedPath, "release.zip")
+	}
+	if !swappedAt.Equal(modTime) {
+		t.Errorf("Swap called with builtAt %v, want %v", swappedAt, modTime)
+	}
+	if len(source.cleanedUp) != 1 || source.cleanedUp[0] != "release.zip" {
+		t.Errorf("Cleanup calls = %v, want exactly one call for release.zip", source.cleanedUp)
+	}
+	if !w.lastModified.Equal(modTime) {
+		t.Errorf("lastModified = %v, want %v", w.lastModified, modTime)
+	}
+}
+
+func TestWatcherCheckOnceCleansUpRejectedCandidate(t *testing.T) {
+	source := &fakeSource{path: "bad.zip", modTime: time.Now(), ok: true}
+	swapped := false
+	w := &Watcher{
+		Source: source,
+		Load: func(path string) (*parser.GeoDataset, error) {
+			return nil, errors.New("corrupt release")
+		},
+		Swap: func(dataset *parser.GeoDataset, builtAt time.Time) {
+			swapped = true
+		},
+	}
+	w.checkOnce()
+
+	if swapped {
+		t.Error("Swap was called for a release that failed to load")
+	}
+	if len(source.cleanedUp) != 1 || source.cleanedUp[0] != "bad.zip" {
+		t.Errorf("Cleanup calls = %v, want exactly one call for bad.zip, even on a failed load", source.cleanedUp)
+	}
+	if !w.lastModified.IsZero() {
+		t.Error("lastModified was advanced for a release that failed to load")
+	}
+}
+
+func TestWatcherCheckOnceNoNewRelease(t *testing.T) {
+	source := &fakeSource{ok: false}
+	called := false
+	w := &Watcher{
+		Source: source,
+		Load: func(path string) (*parser.GeoDataset, error) {
+			called = true
+			return &parser.GeoDataset{}, nil
+		},
+	}
+	w.checkOnce()
+
+	if called {
+		t.Error("Load was called even though CheckNewer reported no new release")
+	}
+}
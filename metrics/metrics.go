@@ -0,0 +1,55 @@
+// Package metrics defines the prometheus metrics exported by
+// annotation-service.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// Metrics_datasetLoadSuccessTime records the unix time of the last
+	// successful dataset reload, so alerting can fire on a release
+	// going stale.
+	Metrics_datasetLoadSuccessTime = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "annotation_dataset_load_success_time",
+		Help: "Unix time of the last successful dataset reload.",
+	})
+
+	// Metrics_datasetReloadFailuresTotal counts failed attempts to poll
+	// for or load a new dataset release, whether the failure was in
+	// checking the source or in verifying the downloaded candidate.
+	Metrics_datasetReloadFailuresTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "annotation_dataset_reload_failures_total",
+		Help: "Total number of failed dataset reload attempts.",
+	})
+
+	// Metrics_datasetCacheHits counts DatasetRegistry.Get calls served
+	// from an already-resident dataset, by release.
+	Metrics_datasetCacheHits = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "annotation_dataset_cache_hits_total",
+		Help: "Total number of historical dataset lookups served from the resident cache, by release.",
+	}, []string{"release"})
+
+	// Metrics_datasetCacheMisses counts DatasetRegistry.Get calls that
+	// had to load their release from disk, by release.
+	Metrics_datasetCacheMisses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "annotation_dataset_cache_misses_total",
+		Help: "Total number of historical dataset lookups that required loading the release from disk, by release.",
+	}, []string{"release"})
+
+	// Metrics_quotaRejectionsTotal counts requests rejected before they
+	// reached a lookup, by reason (e.g. "rate_limit", "batch_too_large").
+	Metrics_quotaRejectionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "annotation_quota_rejections_total",
+		Help: "Total number of requests rejected by quota enforcement, by reason.",
+	}, []string{"reason"})
+
+	// Metrics_batchSize records the number of IPs requested per batch
+	// lookup, so quota limits can be tuned against real traffic.
+	Metrics_batchSize = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "annotation_batch_size",
+		Help:    "Number of IPs requested per batch lookup.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 10),
+	})
+)
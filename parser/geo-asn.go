@@ -0,0 +1,129 @@
+package parser
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strconv"
+
+	"github.com/m-lab/annotation-service/loader"
+)
+
+const (
+	asnNumColumnsGlite2  = 3
+	asnBlocksFilenameIP4 = "GeoLite2-ASN-Blocks-IPv4.csv" // Filename of the ASN ipv4 blocks file
+	asnBlocksFilenameIP6 = "GeoLite2-ASN-Blocks-IPv6.csv" // Filename of the ASN ipv6 blocks file
+)
+
+// ASNNode defines the GeoLite2 ASN blocks databases: network,
+// autonomous_system_number, autonomous_system_organization.
+type ASNNode struct {
+	IPAddressLow  net.IP
+	IPAddressHigh net.IP
+	ASNumber      int64
+	ASName        string
+}
+
+// Low implements Range.
+func (n ASNNode) Low() net.IP {
+	return n.IPAddressLow
+}
+
+// High implements Range.
+func (n ASNNode) High() net.IP {
+	return n.IPAddressHigh
+}
+
+// LoadASNListGLite2 parses a GeoLite2-ASN-Blocks-IPv{4,6}.csv reader
+// into a list of ASNNodes. Unlike the City blocks files, ASN ranges
+// don't nest, so no stack-based flattening is needed here.
+func LoadASNListGLite2(reader io.Reader) ([]ASNNode, error) {
+	list := []ASNNode{}
+	r := csv.NewReader(reader)
+	// Skip the first line
+	_, err := r.Read()
+	if err == io.EOF {
+		log.Println("Empty input data")
+		return nil, errors.New("Empty input data")
+	}
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := checkColumnLength(record, asnNumColumnsGlite2); err != nil {
+			return nil, err
+		}
+		lowIP, highIP, err := RangeCIDR(record[0])
+		if err != nil {
+			return nil, err
+		}
+		asNumber, err := strconv.ParseInt(record[1], 10, 64)
+		if err != nil {
+			log.Println("autonomous_system_number should be a number: ", record[1])
+			return nil, errors.New("Corrupted Data: autonomous_system_number should be a number")
+		}
+		list = append(list, ASNNode{
+			IPAddressLow:  lowIP,
+			IPAddressHigh: highIP,
+			ASNumber:      asNumber,
+			ASName:        record[2],
+		})
+	}
+	return list, nil
+}
+
+// NewASNIndex builds an Index over a slice of ASNNodes.
+func NewASNIndex(nodes []ASNNode) *Index {
+	ranges := make([]Range, len(nodes))
+	for i, n := range nodes {
+		ranges[i] = n
+	}
+	return NewIndex(ranges)
+}
+
+// LoadASN reads the GeoLite2-ASN-Blocks-IPv4.csv and
+// GeoLite2-ASN-Blocks-IPv6.csv files out of zipReader and attaches the
+// resulting indexes to dataset, so lookups through dataset can fill in
+// schema.IPASNData. zipReader reads the GeoLite2 ASN release zip, a separate
+// MaxMind download from the City release LoadGeoLite2 reads.
+func LoadASN(dataset *GeoDataset, zipReader *zip.Reader) error {
+	blocks4, err := loader.FindFile(asnBlocksFilenameIP4, zipReader)
+	if err != nil {
+		return err
+	}
+	asnNodes4, err := LoadASNListGLite2(blocks4)
+	if err != nil {
+		return err
+	}
+	blocks6, err := loader.FindFile(asnBlocksFilenameIP6, zipReader)
+	if err != nil {
+		return err
+	}
+	asnNodes6, err := LoadASNListGLite2(blocks6)
+	if err != nil {
+		return err
+	}
+	dataset.ASN4Index = NewASNIndex(asnNodes4)
+	dataset.ASN6Index = NewASNIndex(asnNodes6)
+	return nil
+}
+
+// LoadASNFile opens the GeoLite2 ASN CSV zip at path and attaches it to
+// dataset via LoadASN. It is the entry point used by the csv backend,
+// the reload watcher, and DatasetRegistry, all of which only ever have
+// a path to work with.
+func LoadASNFile(dataset *GeoDataset, path string) error {
+	reader, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	return LoadASN(dataset, &reader.Reader)
+}
@@ -0,0 +1,51 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+)
+
+const sampleASNCSV = `network,autonomous_system_number,autonomous_system_organization
+1.0.0.0/24,13335,"Cloudflare, Inc."
+1.0.1.0/24,2856,BT-CENTRAL-PLUS
+8.8.8.0/24,15169,Google LLC
+`
+
+func TestLoadASNListGLite2(t *testing.T) {
+	nodes, err := LoadASNListGLite2(strings.NewReader(sampleASNCSV))
+	if err != nil {
+		t.Fatalf("LoadASNListGLite2: %v", err)
+	}
+	if len(nodes) != 3 {
+		t.Fatalf("len(nodes) = %d, want 3", len(nodes))
+	}
+
+	want := []ASNNode{
+		{ASNumber: 13335, ASName: "Cloudflare, Inc."},
+		{ASNumber: 2856, ASName: "BT-CENTRAL-PLUS"},
+		{ASNumber: 15169, ASName: "Google LLC"},
+	}
+	for i, w := range want {
+		if nodes[i].ASNumber != w.ASNumber {
+			t.Errorf("nodes[%d].ASNumber = %d, want %d", i, nodes[i].ASNumber, w.ASNumber)
+		}
+		if nodes[i].ASName != w.ASName {
+			t.Errorf("nodes[%d].ASName = %q, want %q", i, nodes[i].ASName, w.ASName)
+		}
+	}
+
+	idx := NewASNIndex(nodes)
+	rng, err := idx.Search("8.8.8.8")
+	if err != nil {
+		t.Fatalf("Index.Search(8.8.8.8): %v", err)
+	}
+	if got := rng.(ASNNode).ASNumber; got != 15169 {
+		t.Errorf("Search(8.8.8.8).ASNumber = %d, want 15169", got)
+	}
+}
+
+func TestLoadASNListGLite2EmptyInput(t *testing.T) {
+	if _, err := LoadASNListGLite2(strings.NewReader("")); err == nil {
+		t.Fatal("LoadASNListGLite2(empty) = nil error, want an error")
+	}
+}
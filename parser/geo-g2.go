@@ -48,7 +48,19 @@ func LoadGeoLite2(zip *zip.Reader) (*GeoDataset, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &GeoDataset{IP4Nodes: ipNodes4, IP6Nodes: ipNodes6, LocationNodes: locationNode}, nil
+	return &GeoDataset{IP4Index: NewIPIndex(ipNodes4), IP6Index: NewIPIndex(ipNodes6), LocationNodes: locationNode}, nil
+}
+
+// LoadGeoLite2File opens the GeoLite2 CSV zip at path and loads it with
+// LoadGeoLite2. It is the entry point used by the reload watcher, which
+// only ever has a downloaded file path to work with.
+func LoadGeoLite2File(path string) (*GeoDataset, error) {
+	reader, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return LoadGeoLite2(&reader.Reader)
 }
 
 // Finds the smallest and largest net.IP from a CIDR range
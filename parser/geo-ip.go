@@ -3,14 +3,18 @@
 package parser
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"log"
 	"math"
 	"net"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+
+	"github.com/m-lab/etl/schema"
 )
 
 const mapMax = 200000
@@ -25,6 +29,16 @@ type IPNode struct {
 	Longitude     float64
 }
 
+// Low returns the start of the range covered by this node.
+func (n IPNode) Low() net.IP {
+	return n.IPAddressLow
+}
+
+// High returns the end of the range covered by this node.
+func (n IPNode) High() net.IP {
+	return n.IPAddressHigh
+}
+
 // LocationNode defines Location databases
 type LocationNode struct {
 	GeonameID     int
@@ -38,11 +52,108 @@ type LocationNode struct {
 // The GeoDataset struct bundles all the data needed to search and
 // find data into one common structure
 type GeoDataset struct {
-	IP4Nodes      []IPNode       // The IPNode list containing IP4Nodes
-	IP6Nodes      []IPNode       // The IPNode list containing IP6Nodes
+	IP4Index      *Index         // O(log N) index over the IPv4 blocks
+	IP6Index      *Index         // O(log N) index over the IPv6 blocks
 	LocationNodes []LocationNode // The location nodes corresponding to the IPNodes
+
+	// ASN4Index and ASN6Index are nil until LoadASN attaches them; a
+	// GeoDataset built only from LoadGeoLite2 has no ASN data.
+	ASN4Index *Index // O(log N) index over the ASN IPv4 blocks
+	ASN6Index *Index // O(log N) index over the ASN IPv6 blocks
+}
+
+// Range is a contiguous, inclusive IP interval that can be stored in an
+// Index.
+type Range interface {
+	Low() net.IP
+	High() net.IP
+}
+
+// Index is an immutable, binary-searchable view over a set of Ranges.
+// It replaces an O(N) linear scan over a node list with an O(log N)
+// lookup built once at load time.
+//
+// The Ranges backing a GeoDataset are already flattened into disjoint,
+// non-overlapping intervals by LoadIPListGLite2, so sorting them by low
+// address and running a single sort.Search is enough to find the
+// containing range; no inner linear walk is required.
+type Index struct {
+	ranges []Range
+}
+
+// NewIndex builds an Index over ranges, sorting a copy of them by low
+// address. ranges is not modified.
+//
+// Comparisons always go through To16: net.IP can be backed by either a
+// 4-byte or a 16-byte slice, and bytes.Compare on two differing
+// lengths is not a valid ordering. net.ParseIP (used by Search) always
+// returns the 16-byte form, so every Range's bounds are normalized to
+// match regardless of how the Range implementation built them.
+func NewIndex(ranges []Range) *Index {
+	sorted := make([]Range, len(ranges))
+	copy(sorted, ranges)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i].Low().To16(), sorted[j].Low().To16()) < 0
+	})
+	return &Index{ranges: sorted}
 }
 
+// Search returns the Range in the Index that contains userIP.
+func (idx *Index) Search(ipLookUp string) (Range, error) {
+	userIP := net.ParseIP(ipLookUp)
+	if userIP == nil {
+		log.Println("Inputed IP string could not be parsed to net.IP")
+		return nil, errors.New("Invalid search IP")
+	}
+	userIP16 := userIP.To16()
+	// i is the index of the first range whose low address is past
+	// userIP, so the only range that can contain userIP is the one
+	// immediately before it.
+	i := sort.Search(len(idx.ranges), func(i int) bool {
+		return bytes.Compare(idx.ranges[i].Low().To16(), userIP16) > 0
+	}) - 1
+	if i >= 0 && bytes.Compare(userIP16, idx.ranges[i].High().To16()) <= 0 {
+		return idx.ranges[i], nil
+	}
+	return nil, errors.New("Node not found\n")
+}
+
+// Len returns the number of ranges held by the Index.
+func (idx *Index) Len() int {
+	return len(idx.ranges)
+}
+
+// NewIPIndex builds an Index over a slice of IPNodes.
+func NewIPIndex(nodes []IPNode) *Index {
+	ranges := make([]Range, len(nodes))
+	for i, n := range nodes {
+		ranges[i] = n
+	}
+	return NewIndex(ranges)
+}
+
+// ConvertIPNodeToMetaData takes an IPNode, plus a list of
+// LocationNodes. It will then use that data to fill in a
+// schema.MetaData struct and return its pointer.
+func ConvertIPNodeToMetaData(ipNode IPNode, locationNodes []LocationNode) *schema.MetaData {
+	locNode := LocationNode{}
+	if ipNode.LocationIndex >= 0 {
+		locNode = locationNodes[ipNode.LocationIndex]
+	}
+	return &schema.MetaData{
+		Geo: &schema.GeolocationIP{
+			Continent_code: locNode.ContinentCode,
+			Country_code:   locNode.CountryCode,
+			Country_name:   locNode.CountryName,
+			Postal_code:    ipNode.PostalCode,
+			Metro_code:     locNode.MetroCode,
+			City:           locNode.CityName,
+			Latitude:       ipNode.Latitude,
+			Longitude:      ipNode.Longitude,
+		},
+		ASN: &schema.IPASNData{},
+	}
+}
 
 // Verify column length
 func checkColumnLength(record []string, size int) error {
@@ -95,11 +206,13 @@ func RangeCIDR(cidr string) (net.IP, net.IP, error) {
 }
 
 // Finds provided geonameID within idMap and returns the index in idMap
-// locationIdMap := map[int]int{
-//	609013: 0,
-//	104084: 4,
-//	17:     4,
-// }
+//
+//	locationIdMap := map[int]int{
+//		609013: 0,
+//		104084: 4,
+//		17:     2,
+//	}
+//
 // lookupGeoId("17",locationIdMap) would return (2,nil).
 // TODO: Add error metrics
 func lookupGeoId(gnid string, idMap map[int]int) (int, error) {
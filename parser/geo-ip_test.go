@@ -0,0 +1,98 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"net"
+	"testing"
+)
+
+// ipRange is a minimal Range used to build benchmark fixtures without
+// building full IPNodes.
+type ipRange struct {
+	low, high net.IP
+}
+
+func (r ipRange) Low() net.IP  { return r.low }
+func (r ipRange) High() net.IP { return r.high }
+
+// linearSearch is the O(N) scan SearchList used to perform, kept here
+// only so BenchmarkLinearSearch has something to compare Index against.
+func linearSearch(ranges []Range, userIP net.IP) (Range, error) {
+	for i := range ranges {
+		if bytes.Compare(userIP, ranges[i].Low()) >= 0 && bytes.Compare(userIP, ranges[i].High()) <= 0 {
+			return ranges[i], nil
+		}
+	}
+	return nil, errors.New("not found")
+}
+
+// makeFixture builds n disjoint, sorted /24-sized IPv4 ranges, roughly
+// mirroring the shape of a flattened GeoLite2 blocks table.
+func makeFixture(n int) []Range {
+	ranges := make([]Range, n)
+	for i := 0; i < n; i++ {
+		low := make(net.IP, 4)
+		high := make(net.IP, 4)
+		binary.BigEndian.PutUint32(low, uint32(i*256))
+		binary.BigEndian.PutUint32(high, uint32(i*256+255))
+		ranges[i] = ipRange{low: low, high: high}
+	}
+	return ranges
+}
+
+func lookupIPsFor(ranges []Range, n int) []net.IP {
+	ips := make([]net.IP, n)
+	for i := range ips {
+		block := rand.Intn(len(ranges))
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, uint32(block*256+rand.Intn(256)))
+		ips[i] = ip
+	}
+	return ips
+}
+
+func TestIndexSearchMatchesLinearSearch(t *testing.T) {
+	ranges := makeFixture(10000)
+	idx := NewIndex(ranges)
+	for _, userIP := range lookupIPsFor(ranges, 1000) {
+		want, err := linearSearch(ranges, userIP)
+		if err != nil {
+			t.Fatalf("linearSearch(%s): %v", userIP, err)
+		}
+		got, err := idx.Search(userIP.String())
+		if err != nil {
+			t.Fatalf("Index.Search(%s): %v", userIP, err)
+		}
+		if !got.Low().Equal(want.Low()) || !got.High().Equal(want.High()) {
+			t.Errorf("Index.Search(%s) = [%s, %s], want [%s, %s]", userIP, got.Low(), got.High(), want.Low(), want.High())
+		}
+	}
+}
+
+const benchmarkDatasetSize = 1 << 20 // comparable to a full GeoLite2 blocks table
+
+func BenchmarkLinearSearch(b *testing.B) {
+	ranges := makeFixture(benchmarkDatasetSize)
+	lookupIPs := lookupIPsFor(ranges, 1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := linearSearch(ranges, lookupIPs[i%len(lookupIPs)]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkIndexedSearch(b *testing.B) {
+	ranges := makeFixture(benchmarkDatasetSize)
+	idx := NewIndex(ranges)
+	lookupIPs := lookupIPsFor(ranges, 1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := idx.Search(lookupIPs[i%len(lookupIPs)].String()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
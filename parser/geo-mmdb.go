@@ -0,0 +1,62 @@
+package parser
+
+import (
+	"io"
+	"net"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// CityLookup resolves an IP to MaxMind City data. It is satisfied by
+// *geoip2.Reader; tests substitute a fake to exercise MMDBBackend
+// without a real .mmdb file.
+type CityLookup interface {
+	City(ip net.IP) (*geoip2.City, error)
+}
+
+// ASNLookup resolves an IP to MaxMind ASN data. It is satisfied by
+// *geoip2.Reader; tests substitute a fake to exercise MMDBBackend
+// without a real .mmdb file.
+type ASNLookup interface {
+	ASN(ip net.IP) (*geoip2.ASN, error)
+}
+
+// MMDBDataset wraps MaxMind binary database readers, letting
+// annotations be served directly from the .mmdb distribution instead
+// of the CSV blocks/locations files LoadGeoLite2 consumes.
+type MMDBDataset struct {
+	City CityLookup
+	ASN  ASNLookup // nil if no ASN database was loaded
+}
+
+// LoadGeoLite2MMDB opens a GeoLite2-City.mmdb file at cityPath and,
+// when asnPath is non-empty, a companion GeoLite2-ASN.mmdb file.
+func LoadGeoLite2MMDB(cityPath, asnPath string) (*MMDBDataset, error) {
+	city, err := geoip2.Open(cityPath)
+	if err != nil {
+		return nil, err
+	}
+	dataset := &MMDBDataset{City: city}
+	if asnPath != "" {
+		asn, err := geoip2.Open(asnPath)
+		if err != nil {
+			city.Close()
+			return nil, err
+		}
+		dataset.ASN = asn
+	}
+	return dataset, nil
+}
+
+// Close releases the underlying mmdb file handles.
+func (d *MMDBDataset) Close() error {
+	if closer, ok := d.ASN.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			return err
+		}
+	}
+	if closer, ok := d.City.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -1,34 +1,88 @@
+// Package search resolves a single IP address into annotation metadata
+// on behalf of handler. It defines the Backend interface so handler can
+// switch between lookup strategies (the CSV-derived Index, an
+// mmdb-backed lookup) without changing its call sites.
 package search
 
 import (
-	"bytes"
 	"errors"
-	"log"
-	"net"
 
 	"github.com/m-lab/annotation-service/parser"
+	"github.com/m-lab/etl/schema"
 )
 
-// TODO: Add a prometheus metric for when we can't find the IP
-// Returns a parser.IPNode with the smallet range that includes the provided IP address
-func SearchList(list []parser.IPNode, ipLookUp string) (parser.IPNode, error) {
-	inRange := false
-	var lastNodeIndex int
-	userIP := net.ParseIP(ipLookUp)
-	if userIP == nil {
-		log.Println("Inputed IP string could not be parsed to net.IP")
-		return parser.IPNode{}, errors.New("Invalid search IP")
+// Fields selects which parts of a schema.MetaData an Annotate call
+// should compute. Geo and ASN lookups are independent; skipping one
+// when a client only wants the other halves the work per request.
+type Fields struct {
+	Geo bool
+	ASN bool
+}
+
+// AllFields requests every annotation a Backend can provide.
+var AllFields = Fields{Geo: true, ASN: true}
+
+// Backend resolves metadata for a single IP address.
+type Backend interface {
+	Annotate(ipFormat int, ip string, fields Fields) (*schema.MetaData, error)
+}
+
+// IndexBackend is a Backend backed by a parser.GeoDataset loaded from
+// the GeoLite2 CSV distribution.
+type IndexBackend struct {
+	dataset *parser.GeoDataset
+}
+
+// NewIndexBackend wraps dataset as a Backend.
+func NewIndexBackend(dataset *parser.GeoDataset) *IndexBackend {
+	return &IndexBackend{dataset: dataset}
+}
+
+// Annotate implements Backend.
+func (b *IndexBackend) Annotate(ipFormat int, ip string, fields Fields) (*schema.MetaData, error) {
+	metaData := &schema.MetaData{Geo: &schema.GeolocationIP{}, ASN: &schema.IPASNData{}}
+
+	if fields.Geo {
+		idx := b.geoIndex(ipFormat)
+		if idx == nil {
+			return nil, errors.New("Unknown IP Format!")
+		}
+		rng, err := idx.Search(ip)
+		if err != nil {
+			return nil, err
+		}
+		metaData.Geo = parser.ConvertIPNodeToMetaData(rng.(parser.IPNode), b.dataset.LocationNodes).Geo
 	}
-	for i := range list {
-		if bytes.Compare(userIP, list[i].IPAddressLow) >= 0 && bytes.Compare(userIP, list[i].IPAddressHigh) <= 0 {
-			inRange = true
-			lastNodeIndex = i
-		} else if inRange && bytes.Compare(userIP, list[i].IPAddressLow) < 0 {
-			return list[lastNodeIndex], nil
+
+	if fields.ASN {
+		if idx := b.asnIndex(ipFormat); idx != nil {
+			if rng, err := idx.Search(ip); err == nil {
+				asnNode := rng.(parser.ASNNode)
+				metaData.ASN.ASNumber = asnNode.ASNumber
+				metaData.ASN.ASName = asnNode.ASName
+			}
 		}
 	}
-	if inRange {
-		return list[lastNodeIndex], nil
+
+	return metaData, nil
+}
+
+func (b *IndexBackend) geoIndex(ipFormat int) *parser.Index {
+	if ipFormat == 4 {
+		return b.dataset.IP4Index
+	}
+	if ipFormat == 6 {
+		return b.dataset.IP6Index
+	}
+	return nil
+}
+
+func (b *IndexBackend) asnIndex(ipFormat int) *parser.Index {
+	if ipFormat == 4 {
+		return b.dataset.ASN4Index
+	}
+	if ipFormat == 6 {
+		return b.dataset.ASN6Index
 	}
-	return parser.IPNode{}, errors.New("Node not found\n")
+	return nil
 }
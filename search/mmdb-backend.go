@@ -0,0 +1,57 @@
+package search
+
+import (
+	"errors"
+	"net"
+
+	"github.com/m-lab/annotation-service/parser"
+	"github.com/m-lab/etl/schema"
+)
+
+// MMDBBackend is a Backend backed by a parser.MMDBDataset, i.e. a
+// MaxMind binary (.mmdb) database opened directly instead of the
+// GeoLite2 CSV distribution.
+type MMDBBackend struct {
+	dataset *parser.MMDBDataset
+}
+
+// NewMMDBBackend wraps dataset as a Backend.
+func NewMMDBBackend(dataset *parser.MMDBDataset) *MMDBBackend {
+	return &MMDBBackend{dataset: dataset}
+}
+
+// Annotate implements Backend. ipFormat is accepted for interface
+// parity with IndexBackend; geoip2.Reader.City accepts either address
+// family directly.
+func (b *MMDBBackend) Annotate(ipFormat int, ip string, fields Fields) (*schema.MetaData, error) {
+	userIP := net.ParseIP(ip)
+	if userIP == nil {
+		return nil, errors.New("Invalid search IP")
+	}
+	metaData := &schema.MetaData{Geo: &schema.GeolocationIP{}, ASN: &schema.IPASNData{}}
+
+	if fields.Geo {
+		city, err := b.dataset.City.City(userIP)
+		if err != nil {
+			return nil, err
+		}
+		metaData.Geo = &schema.GeolocationIP{
+			Continent_code: city.Continent.Code,
+			Country_code:   city.Country.IsoCode,
+			Country_name:   city.Country.Names["en"],
+			Postal_code:    city.Postal.Code,
+			City:           city.City.Names["en"],
+			Latitude:       city.Location.Latitude,
+			Longitude:      city.Location.Longitude,
+		}
+	}
+
+	if fields.ASN && b.dataset.ASN != nil {
+		if asn, err := b.dataset.ASN.ASN(userIP); err == nil {
+			metaData.ASN.ASNumber = int64(asn.AutonomousSystemNumber)
+			metaData.ASN.ASName = asn.AutonomousSystemOrganization
+		}
+	}
+
+	return metaData, nil
+}
@@ -0,0 +1,118 @@
+package search
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/m-lab/annotation-service/parser"
+	"github.com/oschwald/geoip2-golang"
+)
+
+// fakeCityLookup and fakeASNLookup stand in for the mmdb file readers
+// MMDBBackend normally talks to, so Annotate's field-filtering
+// branches can be exercised without a real .mmdb fixture on disk.
+type fakeCityLookup struct {
+	city *geoip2.City
+	err  error
+}
+
+func (f fakeCityLookup) City(ip net.IP) (*geoip2.City, error) { return f.city, f.err }
+
+type fakeASNLookup struct {
+	asn *geoip2.ASN
+	err error
+}
+
+func (f fakeASNLookup) ASN(ip net.IP) (*geoip2.ASN, error) { return f.asn, f.err }
+
+func sampleCity() *geoip2.City {
+	city := &geoip2.City{}
+	city.Continent.Code = "NA"
+	city.Country.IsoCode = "US"
+	city.Country.Names = map[string]string{"en": "United States"}
+	city.City.Names = map[string]string{"en": "Mountain View"}
+	city.Postal.Code = "94043"
+	city.Location.Latitude = 37.386
+	city.Location.Longitude = -122.0838
+	return city
+}
+
+func sampleASN() *geoip2.ASN {
+	return &geoip2.ASN{AutonomousSystemNumber: 15169, AutonomousSystemOrganization: "Google LLC"}
+}
+
+func TestMMDBBackendAnnotateGeoOnly(t *testing.T) {
+	dataset := &parser.MMDBDataset{City: fakeCityLookup{city: sampleCity()}}
+	b := NewMMDBBackend(dataset)
+
+	got, err := b.Annotate(4, "8.8.8.8", Fields{Geo: true})
+	if err != nil {
+		t.Fatalf("Annotate: %v", err)
+	}
+	if got.Geo.Country_code != "US" || got.Geo.City != "Mountain View" {
+		t.Errorf("Geo = %+v, want US/Mountain View", got.Geo)
+	}
+	if got.ASN.ASNumber != 0 || got.ASN.ASName != "" {
+		t.Errorf("ASN = %+v, want zero value when fields.ASN is false", got.ASN)
+	}
+}
+
+func TestMMDBBackendAnnotateASNOnly(t *testing.T) {
+	dataset := &parser.MMDBDataset{City: fakeCityLookup{city: sampleCity()}, ASN: fakeASNLookup{asn: sampleASN()}}
+	b := NewMMDBBackend(dataset)
+
+	got, err := b.Annotate(4, "8.8.8.8", Fields{ASN: true})
+	if err != nil {
+		t.Fatalf("Annotate: %v", err)
+	}
+	if got.ASN.ASNumber != 15169 || got.ASN.ASName != "Google LLC" {
+		t.Errorf("ASN = %+v, want 15169/Google LLC", got.ASN)
+	}
+	if got.Geo.Country_code != "" {
+		t.Errorf("Geo = %+v, want zero value when fields.Geo is false", got.Geo)
+	}
+}
+
+func TestMMDBBackendAnnotateBoth(t *testing.T) {
+	dataset := &parser.MMDBDataset{City: fakeCityLookup{city: sampleCity()}, ASN: fakeASNLookup{asn: sampleASN()}}
+	b := NewMMDBBackend(dataset)
+
+	got, err := b.Annotate(4, "8.8.8.8", AllFields)
+	if err != nil {
+		t.Fatalf("Annotate: %v", err)
+	}
+	if got.Geo.Country_code != "US" {
+		t.Errorf("Geo.Country_code = %q, want US", got.Geo.Country_code)
+	}
+	if got.ASN.ASNumber != 15169 {
+		t.Errorf("ASN.ASNumber = %d, want 15169", got.ASN.ASNumber)
+	}
+}
+
+func TestMMDBBackendAnnotateMissingASNReader(t *testing.T) {
+	dataset := &parser.MMDBDataset{City: fakeCityLookup{city: sampleCity()}}
+	b := NewMMDBBackend(dataset)
+
+	got, err := b.Annotate(4, "8.8.8.8", AllFields)
+	if err != nil {
+		t.Fatalf("Annotate: %v", err)
+	}
+	if got.ASN.ASNumber != 0 || got.ASN.ASName != "" {
+		t.Errorf("ASN = %+v, want zero value when no ASN database was loaded", got.ASN)
+	}
+}
+
+func TestMMDBBackendAnnotateInvalidIP(t *testing.T) {
+	b := NewMMDBBackend(&parser.MMDBDataset{City: fakeCityLookup{city: sampleCity()}})
+	if _, err := b.Annotate(4, "not-an-ip", AllFields); err == nil {
+		t.Error("Annotate(\"not-an-ip\") = nil error, want an error")
+	}
+}
+
+func TestMMDBBackendAnnotateCityLookupError(t *testing.T) {
+	b := NewMMDBBackend(&parser.MMDBDataset{City: fakeCityLookup{err: errors.New("boom")}})
+	if _, err := b.Annotate(4, "8.8.8.8", Fields{Geo: true}); err == nil {
+		t.Error("Annotate with a failing City lookup = nil error, want an error")
+	}
+}